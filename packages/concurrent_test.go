@@ -0,0 +1,114 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/tools/go/gcexportdata"
+)
+
+// writeTestExport writes gcexport data for an empty, complete package named
+// pkgPath into dir/name and returns the file it was written to.
+func writeTestExport(t *testing.T, dir, name, pkgPath string) string {
+	t.Helper()
+	pkg := types.NewPackage(pkgPath, filepath.Base(pkgPath))
+	pkg.MarkComplete()
+	file := filepath.Join(dir, name+".a")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := gcexportdata.Write(f, token.NewFileSet(), pkg); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newTestImporter(pkgs map[string]pkgExport) *Importer {
+	return &Importer{
+		pkgs:     pkgs,
+		loaded:   map[string]*types.Package{"unsafe": types.Unsafe},
+		fset:     token.NewFileSet(),
+		inFlight: make(map[string]*importResult),
+	}
+}
+
+func TestImportConcurrentNoRace(t *testing.T) {
+	dir := t.TempDir()
+	const n = 100
+	pkgs := make(map[string]pkgExport, n)
+	for i := 0; i < n; i++ {
+		pkgPath := fmt.Sprintf("example.com/m/p%d", i)
+		pkgs[pkgPath] = writeTestExport(t, dir, fmt.Sprintf("p%d", i), pkgPath)
+	}
+	imp := newTestImporter(pkgs)
+
+	var g errgroup.Group
+	for pkgPath := range pkgs {
+		pkgPath := pkgPath
+		g.Go(func() error {
+			_, err := imp.Import(pkgPath)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(imp.loaded) != n+1 { // +1 for "unsafe"
+		t.Fatalf("want %d loaded packages, got %d", n+1, len(imp.loaded))
+	}
+}
+
+func TestImportSingleflight(t *testing.T) {
+	dir := t.TempDir()
+	pkgPath := "example.com/m/dup"
+	pkgs := map[string]pkgExport{pkgPath: writeTestExport(t, dir, "dup", pkgPath)}
+	imp := newTestImporter(pkgs)
+
+	const n = 50
+	var wg sync.WaitGroup
+	results := make([]*types.Package, n)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+			pkg, err := imp.Import(pkgPath)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results[i] = pkg
+		}()
+	}
+	wg.Wait()
+
+	if got := imp.loadCount; got != 1 {
+		t.Fatalf("want exactly 1 loadPkgExport invocation, got %d", got)
+	}
+	for i, pkg := range results {
+		if pkg != results[0] {
+			t.Fatalf("result %d: got a different *types.Package than result 0; singleflight failed to dedup", i)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"go/build/constraint"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// matchName reports whether name (a file's base name) is compatible with
+// goos/goarch: it returns false only if name carries a _GOOS, _GOARCH, or
+// _GOOS_GOARCH suffix that disagrees with them.
+// It mirrors the algorithm used by go/build.Context.goodOSArchFile.
+func matchName(name, goos, goarch string) bool {
+	if dot := strings.Index(name, "."); dot >= 0 {
+		name = name[:dot]
+	}
+
+	// Before Go 1.4, a file called "linux.go" would be equivalent to having
+	// a build tag "linux" in that file. For Go 1.4 and beyond, the tag must
+	// be introduced by an underscore, so a name with no underscore at all
+	// (e.g. "windows.go") carries no suffix constraint.
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return true
+	}
+	name = name[i:]
+
+	l := strings.Split(name, "_")
+	if n := len(l); n > 0 && l[n-1] == "test" {
+		l = l[:n-1]
+	}
+	n := len(l)
+	if n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]] {
+		return l[n-2] == goos && l[n-1] == goarch
+	}
+	if n >= 1 && knownOS[l[n-1]] {
+		return l[n-1] == goos
+	}
+	if n >= 1 && knownArch[l[n-1]] {
+		return l[n-1] == goarch
+	}
+	return true
+}
+
+// readBuildConstraint scans the leading comments of the Go source file at
+// path — up to the package clause — and returns the combined //go:build /
+// // +build constraint expression found there, or nil if none is present.
+func readBuildConstraint(path string) (constraint.Expr, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readBuildConstraintFrom(f)
+}
+
+// readBuildConstraintFrom is readBuildConstraint generalized over any
+// reader, so in-memory (overlay) content can be scanned the same way as an
+// on-disk file.
+func readBuildConstraintFrom(r io.Reader) (constraint.Expr, error) {
+	var expr constraint.Expr
+	sc := bufio.NewScanner(r)
+	inBlockComment := false
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if inBlockComment {
+			end := strings.Index(line, "*/")
+			if end < 0 {
+				continue
+			}
+			inBlockComment = false
+			line = strings.TrimSpace(line[end+len("*/"):])
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/*") {
+			rest := line[len("/*"):]
+			if end := strings.Index(rest, "*/"); end >= 0 {
+				line = strings.TrimSpace(rest[end+len("*/"):])
+				if line == "" {
+					continue
+				}
+			} else {
+				inBlockComment = true
+				continue
+			}
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		if constraint.IsGoBuild(line) || constraint.IsPlusBuild(line) {
+			x, err := constraint.Parse(line)
+			if err != nil {
+				continue
+			}
+			if expr == nil {
+				expr = x
+			} else {
+				expr = &constraint.AndExpr{X: expr, Y: x}
+			}
+		}
+	}
+	return expr, sc.Err()
+}
+
+// evalConstraint reports whether expr is satisfied by cfg's GOOS, GOARCH,
+// BuildTags, and CgoEnabled settings. A nil expr is always satisfied.
+func evalConstraint(expr constraint.Expr, cfg *Config) bool {
+	if expr == nil {
+		return true
+	}
+	goos, goarch := cfg.goos(), cfg.goarch()
+	return expr.Eval(func(tag string) bool {
+		switch tag {
+		case "cgo":
+			return cfg.CgoEnabled
+		case goos, goarch:
+			return true
+		}
+		for _, t := range cfg.BuildTags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MatchFile reports whether the source file at path should be included when
+// building for the configuration in cfg: its name suffix must agree with
+// cfg's GOOS/GOARCH (if any), and any //go:build or // +build constraints in
+// its header must evaluate to true under cfg's GOOS, GOARCH, BuildTags, and
+// CgoEnabled. If cfg.Overlay has an entry for path, its in-memory content is
+// used instead of reading the file from disk.
+func MatchFile(path string, cfg *Config) (bool, error) {
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	if !matchName(filepath.Base(path), cfg.goos(), cfg.goarch()) {
+		return false, nil
+	}
+	var expr constraint.Expr
+	var err error
+	if content, ok := cfg.Overlay[path]; ok {
+		expr, err = readBuildConstraintFrom(bytes.NewReader(content))
+	} else {
+		expr, err = readBuildConstraint(path)
+	}
+	if err != nil {
+		return false, err
+	}
+	return evalConstraint(expr, cfg), nil
+}
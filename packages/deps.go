@@ -0,0 +1,77 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// loadDeps resolves each of pkgPaths to its compiled export data file by
+// shelling out to `go list -export -json`: the standard, toolchain-owned way
+// to get a package's export data without reimplementing compiler invocation
+// here. conf.Overlay, conf.BuildTags, and conf.BuildFlags are all forwarded
+// to the underlying `go list` invocation.
+func loadDeps(conf *Config, pkgPaths ...string) (map[string]pkgExport, error) {
+	pkgs := make(map[string]pkgExport, len(pkgPaths))
+	if len(pkgPaths) == 0 {
+		return pkgs, nil
+	}
+
+	args := []string{"list", "-e", "-export", "-json"}
+	args = append(args, conf.BuildFlags...)
+	if len(conf.BuildTags) > 0 {
+		args = append(args, "-tags="+strings.Join(conf.BuildTags, ","))
+	}
+
+	overlay, cleanup, err := writeOverlayJSON(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if overlay != "" {
+		args = append(args, "-overlay="+overlay)
+	}
+
+	args = append(args, pkgPaths...)
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = conf.ModRoot
+	cmd.Env = append(os.Environ(), "GOOS="+conf.goos(), "GOARCH="+conf.goarch())
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go list: %v: %s", err, stderr.Bytes())
+	}
+
+	dec := json.NewDecoder(&stdout)
+	for dec.More() {
+		var jp struct {
+			ImportPath string
+			Export     string
+		}
+		if err := dec.Decode(&jp); err != nil {
+			return nil, fmt.Errorf("go list: invalid output: %v", err)
+		}
+		if jp.Export != "" {
+			pkgs[jp.ImportPath] = jp.Export
+		}
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,162 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Workspaces built by Bazel or Buck rather than `go build` integrate via the
+// GOPACKAGESDRIVER JSON-over-stdio protocol (see golang.org/x/tools/go/packages):
+// the driver executable is fed a request on stdin and answers with the
+// packages it resolved, including the gcexport data file for each.
+
+// needExportFile is the only bit this importer ever sets in driverRequest.Mode:
+// it only ever needs each resolved package's export data file, not its full
+// syntax tree or source list. It matches NeedExportFile's bit position in
+// golang.org/x/tools/go/packages.LoadMode, since real GOPACKAGESDRIVER
+// implementations interpret Mode using those bit positions.
+const needExportFile = 1 << 5
+
+// driverRequest is what's written to the driver's stdin.
+type driverRequest struct {
+	Mode       int               `json:"mode"`
+	Env        []string          `json:"env"`
+	BuildFlags []string          `json:"build_flags"`
+	Patterns   []string          `json:"patterns"`
+	Overlay    map[string][]byte `json:"overlay"`
+}
+
+// driverPackage is one package in a driverResponse.
+type driverPackage struct {
+	ID         string `json:"ID"`
+	PkgPath    string `json:"PkgPath"`
+	ExportFile string `json:"ExportFile"`
+}
+
+// driverResponse is what's read back from the driver's stdout.
+type driverResponse struct {
+	// NotHandled is returned if the request can't be handled by the driver,
+	// in which case the caller should fall back to go/list-style loading.
+	NotHandled bool `json:"NotHandled"`
+
+	// Roots are the IDs of the packages matching the request's patterns,
+	// in the order the driver resolved them.
+	Roots []string `json:"Roots"`
+
+	Packages []*driverPackage `json:"Packages"`
+}
+
+// driverPath returns the driver executable to use, preferring conf.Driver
+// over the GOPACKAGESDRIVER environment variable.
+func driverPath(conf *Config) string {
+	if conf.Driver != "" {
+		return conf.Driver
+	}
+	return os.Getenv("GOPACKAGESDRIVER")
+}
+
+// runDriver execs the configured driver, feeding it patterns and the
+// configured overlay, and returns its parsed response.
+func runDriver(conf *Config, patterns []string) (*driverResponse, error) {
+	driver := driverPath(conf)
+	env := append(os.Environ(), conf.DriverEnv...)
+	env = append(env, "GOOS="+conf.goos(), "GOARCH="+conf.goarch())
+
+	buildFlags := conf.BuildFlags
+	if len(conf.BuildTags) > 0 {
+		buildFlags = append(append([]string{}, buildFlags...), "-tags="+strings.Join(conf.BuildTags, ","))
+	}
+
+	req := driverRequest{
+		Mode:       needExportFile,
+		Env:        env,
+		BuildFlags: buildFlags,
+		Patterns:   patterns,
+		Overlay:    conf.Overlay,
+	}
+	reqData, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(driver, patterns...)
+	cmd.Stdin = bytes.NewReader(reqData)
+	cmd.Env = env
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", driver, err, stderr.Bytes())
+	}
+
+	var resp driverResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("%s: invalid response: %v", driver, err)
+	}
+	return &resp, nil
+}
+
+// newImporterFromDriver implements the GOPACKAGESDRIVER path of NewImporter:
+// it bypasses listPkgs+loadDeps entirely and populates pkgs straight from
+// the driver's response. loadPkgExport still reads each ExportFile with
+// gcexportdata, since rules_go and similar already emit standard gcexport
+// data.
+func newImporterFromDriver(conf *Config, pattern ...string) (p *Importer, pkgPaths []string, err error) {
+	resp, err := runDriver(conf, pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.NotHandled {
+		fallback := *conf
+		fallback.Driver = ""
+		return newImporterListLoad(&fallback, pattern...)
+	}
+
+	pkgs := make(map[string]pkgExport, len(resp.Packages))
+	idToPath := make(map[string]string, len(resp.Packages))
+	for _, dp := range resp.Packages {
+		idToPath[dp.ID] = dp.PkgPath
+		if dp.ExportFile == "" {
+			continue
+		}
+		pkgs[dp.PkgPath] = dp.ExportFile
+	}
+	pkgPaths = make([]string, 0, len(resp.Roots))
+	for _, id := range resp.Roots {
+		if pkgPath, ok := idToPath[id]; ok {
+			id = pkgPath
+		}
+		pkgPaths = append(pkgPaths, id)
+	}
+
+	fset := conf.Fset
+	if fset == nil {
+		fset = token.NewFileSet()
+	}
+	loaded := conf.Loaded
+	if loaded == nil {
+		loaded = make(map[string]*types.Package)
+	}
+	loaded["unsafe"] = types.Unsafe
+	p = &Importer{pkgs: pkgs, loaded: loaded, fset: fset, inFlight: make(map[string]*importResult)}
+	return
+}
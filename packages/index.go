@@ -0,0 +1,327 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"go/build/constraint"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Modeled on cmd/go/internal/modindex: a single binary file per module,
+// keyed by a fingerprint of (ModPath, SupportedExts, go version, the set of
+// (relpath, mtime, size) tuples under ModRoot), that lets List/Load skip
+// re-reading the whole tree when nothing relevant has changed.
+
+func (p *Config) indexDir() string {
+	return filepath.Join(p.ModRoot, ".gop/_index")
+}
+
+func (p *Config) indexPath() string {
+	return filepath.Join(p.indexDir(), "packages.idx")
+}
+
+func supportedExts(conf *Config) map[string]struct{} {
+	if conf.SupportedExts != nil {
+		return conf.SupportedExts
+	}
+	return defaultSupportedExts
+}
+
+// indexedFile records enough about a single source file to decide, without
+// re-reading it, whether it still belongs to its package under a given
+// GOOS/GOARCH/BuildTags/CgoEnabled configuration.
+type indexedFile struct {
+	Name       string // base name
+	ModTime    int64
+	Size       int64
+	Constraint string // normalized "//go:build <expr>" line (also for // +build files); empty if unconstrained
+}
+
+// indexedPkg is one directory's worth of indexed source files.
+type indexedPkg struct {
+	Dir     string // relative to ModRoot, slash-separated ("." for ModRoot itself)
+	PkgPath string
+	Files   []indexedFile
+	// ImportsHash summarizes this package's file set (see importsHash). It
+	// lets a downstream compile cache, outside this package, key its
+	// gcexport output on a package's files without hashing file content
+	// itself; nothing in this package reads it back yet.
+	ImportsHash string
+}
+
+// moduleIndex is the on-disk representation of a module's package layout.
+type moduleIndex struct {
+	Fingerprint string
+	Pkgs        []indexedPkg
+}
+
+// fileStat is the (relpath, mtime, size) tuple the fingerprint is built from.
+type fileStat struct {
+	Path    string
+	ModTime int64
+	Size    int64
+}
+
+// scanFileStats walks modRoot collecting a sorted (relpath, mtime, size)
+// tuple for every file with a supported extension. It never opens a file,
+// which is what makes it cheap enough to run on every List/Load call.
+func scanFileStats(modRoot string, exts map[string]struct{}) ([]fileStat, error) {
+	var stats []fileStat
+	err := filepath.WalkDir(modRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if p != modRoot && (strings.HasPrefix(name, "_") || name == ".git" || name == ".gop") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := exts[filepath.Ext(name)]; !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(modRoot, p)
+		if err != nil {
+			return err
+		}
+		stats = append(stats, fileStat{
+			Path:    filepath.ToSlash(rel),
+			ModTime: info.ModTime().UnixNano(),
+			Size:    info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Path < stats[j].Path })
+	return stats, nil
+}
+
+// fingerprint hashes conf's module path, supported extensions, the Go
+// version, and the given file stats into the key used to detect a stale
+// on-disk index.
+func fingerprint(conf *Config, stats []fileStat) string {
+	h := sha256.New()
+	fmt.Fprintln(h, conf.ModPath)
+	exts := make([]string, 0, len(supportedExts(conf)))
+	for ext := range supportedExts(conf) {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	fmt.Fprintln(h, exts)
+	fmt.Fprintln(h, runtime.Version())
+	for _, st := range stats {
+		fmt.Fprintln(h, st.Path, st.ModTime, st.Size)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func readIndex(conf *Config) (*moduleIndex, error) {
+	f, err := os.Open(conf.indexPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var idx moduleIndex
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&idx); err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// writeIndex atomically rewrites the on-disk index via a temp file + rename.
+func writeIndex(conf *Config, idx *moduleIndex) error {
+	dir := conf.indexDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "packages.idx.*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, conf.indexPath())
+}
+
+// importsHash summarizes a package's file set into the value stored as
+// indexedPkg.ImportsHash.
+func importsHash(files []indexedFile) string {
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintln(h, f.Name, f.ModTime, f.Size, f.Constraint)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// buildIndex does a full rescan of conf.ModRoot, parsing the build
+// constraint out of each candidate source file.
+func buildIndex(conf *Config) (*moduleIndex, error) {
+	exts := supportedExts(conf)
+	stats, err := scanFileStats(conf.ModRoot, exts)
+	if err != nil {
+		return nil, err
+	}
+	byDir := make(map[string][]fileStat)
+	var dirs []string
+	for _, st := range stats {
+		dir := path.Dir(st.Path)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], st)
+	}
+	sort.Strings(dirs)
+
+	pkgs := make([]indexedPkg, 0, len(dirs))
+	for _, dir := range dirs {
+		files := byDir[dir]
+		ifiles := make([]indexedFile, 0, len(files))
+		for _, st := range files {
+			abs := filepath.Join(conf.ModRoot, filepath.FromSlash(st.Path))
+			expr, err := readBuildConstraint(abs)
+			if err != nil {
+				return nil, err
+			}
+			cons := ""
+			if expr != nil {
+				// Store the full //go:build line, not just expr.String(),
+				// so hasSource can round-trip it back through
+				// constraint.Parse.
+				cons = "//go:build " + expr.String()
+			}
+			ifiles = append(ifiles, indexedFile{
+				Name:       path.Base(st.Path),
+				ModTime:    st.ModTime,
+				Size:       st.Size,
+				Constraint: cons,
+			})
+		}
+		pkgPath := conf.ModPath
+		if dir != "." {
+			pkgPath = path.Join(conf.ModPath, dir)
+		}
+		pkgs = append(pkgs, indexedPkg{
+			Dir:         dir,
+			PkgPath:     pkgPath,
+			Files:       ifiles,
+			ImportsHash: importsHash(ifiles),
+		})
+	}
+	return &moduleIndex{Fingerprint: fingerprint(conf, stats), Pkgs: pkgs}, nil
+}
+
+// loadIndex returns a valid index for conf, transparently rebuilding it when
+// missing or stale.
+func loadIndex(conf *Config) (*moduleIndex, error) {
+	stats, err := scanFileStats(conf.ModRoot, supportedExts(conf))
+	if err != nil {
+		return nil, err
+	}
+	key := fingerprint(conf, stats)
+	if idx, err := readIndex(conf); err == nil && idx.Fingerprint == key {
+		return idx, nil
+	}
+	idx, err := buildIndex(conf)
+	if err != nil {
+		return nil, err
+	}
+	_ = writeIndex(conf, idx)
+	return idx, nil
+}
+
+// Refresh forces a full rebuild of conf's on-disk package index.
+func Refresh(conf *Config) error {
+	if conf == nil {
+		conf = new(Config)
+	}
+	idx, err := buildIndex(conf)
+	if err != nil {
+		return err
+	}
+	return writeIndex(conf, idx)
+}
+
+// pkgPaths filters the indexed packages down to those under dirPat
+// ("" for ModRoot itself), honoring recursive, and evaluating each package's
+// cached build constraints against conf instead of re-reading any file.
+func (idx *moduleIndex) pkgPaths(conf *Config, dirPat string, recursive bool) []string {
+	var pkgPaths []string
+	for _, pkg := range idx.Pkgs {
+		dir := pkg.Dir
+		if dir == "." {
+			dir = ""
+		}
+		switch {
+		case dir == dirPat:
+			// exact match, always included
+		case recursive && dirPat == "":
+			// root pattern covers every package when recursive
+		case recursive && strings.HasPrefix(dir, dirPat+"/"):
+			// nested under dirPat
+		default:
+			continue
+		}
+		if pkg.hasSource(conf) {
+			pkgPaths = append(pkgPaths, pkg.PkgPath)
+		}
+	}
+	return pkgPaths
+}
+
+// hasSource reports whether at least one of pkg's files survives name and
+// build-constraint matching against conf.
+func (pkg *indexedPkg) hasSource(conf *Config) bool {
+	for _, f := range pkg.Files {
+		if !matchName(f.Name, conf.goos(), conf.goarch()) {
+			continue
+		}
+		if f.Constraint == "" {
+			return true
+		}
+		expr, err := constraint.Parse(f.Constraint)
+		if err != nil {
+			continue
+		}
+		if evalConstraint(expr, conf) {
+			return true
+		}
+	}
+	return false
+}
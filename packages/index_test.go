@@ -0,0 +1,126 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexFileAdded(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.go"), "package a\n")
+	conf := &Config{ModRoot: root, ModPath: "example.com/m"}
+
+	idx1, err := loadIndex(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx1.Pkgs) != 1 || len(idx1.Pkgs[0].Files) != 1 {
+		t.Fatalf("want 1 pkg with 1 file, got %+v", idx1.Pkgs)
+	}
+
+	writeTestFile(t, filepath.Join(root, "b.go"), "package a\n")
+	idx2, err := loadIndex(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx2.Fingerprint == idx1.Fingerprint {
+		t.Fatal("fingerprint should change when a file is added")
+	}
+	if len(idx2.Pkgs[0].Files) != 2 {
+		t.Fatalf("want 2 files after adding b.go, got %d", len(idx2.Pkgs[0].Files))
+	}
+}
+
+func TestIndexFileRemoved(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.go"), "package a\n")
+	writeTestFile(t, filepath.Join(root, "b.go"), "package a\n")
+	conf := &Config{ModRoot: root, ModPath: "example.com/m"}
+
+	idx1, err := loadIndex(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx1.Pkgs[0].Files) != 2 {
+		t.Fatalf("want 2 files, got %d", len(idx1.Pkgs[0].Files))
+	}
+
+	if err := os.Remove(filepath.Join(root, "b.go")); err != nil {
+		t.Fatal(err)
+	}
+	idx2, err := loadIndex(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx2.Pkgs[0].Files) != 1 {
+		t.Fatalf("want 1 file after removing b.go, got %d", len(idx2.Pkgs[0].Files))
+	}
+}
+
+func TestIndexMTimeBumped(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "a.go")
+	writeTestFile(t, file, "package a\n")
+	conf := &Config{ModRoot: root, ModPath: "example.com/m"}
+
+	idx1, err := loadIndex(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, future, future); err != nil {
+		t.Fatal(err)
+	}
+	idx2, err := loadIndex(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx2.Fingerprint == idx1.Fingerprint {
+		t.Fatal("fingerprint should change when a file's mtime changes")
+	}
+}
+
+func TestIndexBuildTagFlipped(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.go"), "//go:build linux\n\npackage a\n")
+	conf := &Config{ModRoot: root, ModPath: "example.com/m", GOOS: "linux"}
+
+	idx, err := loadIndex(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idx.Pkgs[0].hasSource(conf) {
+		t.Fatal("package should have source when GOOS matches the build tag")
+	}
+
+	conf.GOOS = "windows"
+	if idx.Pkgs[0].hasSource(conf) {
+		t.Fatal("package should have no source once GOOS no longer matches")
+	}
+}
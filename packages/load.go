@@ -14,15 +14,21 @@
 package packages
 
 import (
+	"bytes"
 	"fmt"
 	"go/token"
 	"go/types"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/tools/go/gcexportdata"
 )
 
@@ -42,6 +48,68 @@ type Config struct {
 	// Fset provides source position information for syntax trees and types (optional).
 	// If Fset is nil, Load will use a new fileset, but preserve Fset's value.
 	Fset *token.FileSet
+
+	// GOOS specifies the target operating system (optional, defaults to runtime.GOOS).
+	GOOS string
+
+	// GOARCH specifies the target architecture (optional, defaults to runtime.GOARCH).
+	GOARCH string
+
+	// BuildTags specifies additional build tags satisfied when evaluating
+	// //go:build and // +build constraints (optional).
+	BuildTags []string
+
+	// CgoEnabled reports whether files guarded by the "cgo" build tag are
+	// included (optional).
+	CgoEnabled bool
+
+	// DisableIndex disables the on-disk package index under
+	// <ModRoot>/.gop/_index/ and always falls back to a full tree walk
+	// (optional).
+	DisableIndex bool
+
+	// Overlay maps absolute file paths to their in-memory, possibly unsaved
+	// content, letting List/Load see editor buffers instead of what's on
+	// disk (optional).
+	Overlay map[string][]byte
+
+	// Driver, if non-empty, names a GOPACKAGESDRIVER-protocol executable to
+	// load packages from instead of walking ModRoot (optional). It falls
+	// back to the GOPACKAGESDRIVER environment variable when empty.
+	Driver string
+
+	// DriverEnv specifies extra environment variables passed to Driver
+	// (optional).
+	DriverEnv []string
+
+	// Concurrency specifies how many packages Load imports at once
+	// (optional, defaults to runtime.GOMAXPROCS(0)).
+	Concurrency int
+
+	// Tests reports whether, for directories containing _test.go files,
+	// listPkgs also emits the synthetic test-variant package paths (see
+	// doListPkgs) so test code can be type-checked or analyzed (optional).
+	Tests bool
+
+	// BuildFlags specifies extra flags (e.g. -tags, -race) forwarded to
+	// whatever actually invokes the compiler for a given pkgPath: loadDeps
+	// for the go/list-style path, or the driver request's BuildFlags field
+	// when conf.Driver is set (optional).
+	BuildFlags []string
+}
+
+func (p *Config) goos() string {
+	if p.GOOS != "" {
+		return p.GOOS
+	}
+	return runtime.GOOS
+}
+
+func (p *Config) goarch() string {
+	if p.GOARCH != "" {
+		return p.GOARCH
+	}
+	return runtime.GOARCH
 }
 
 var (
@@ -69,43 +137,93 @@ func (p *Config) listPkgs(pkgPaths []string, pat, modRoot string) ([]string, err
 		if err1 != nil || err2 != nil || strings.HasPrefix(patRel, "..") {
 			return nil, fmt.Errorf("directory `%s` outside available modules", pat)
 		}
-		exts := p.SupportedExts
-		if exts == nil {
-			exts = defaultSupportedExts
-		}
 		pkgPathBase := path.Join(p.ModPath, filepath.ToSlash(patRel))
-		return doListPkgs(pkgPaths, pkgPathBase, pat, exts, recursive)
+		if !p.DisableIndex && len(p.Overlay) == 0 && !p.Tests {
+			if idx, err := loadIndex(p); err == nil {
+				dirPat := filepath.ToSlash(patRel)
+				if dirPat == "." {
+					dirPat = ""
+				}
+				return append(pkgPaths, idx.pkgPaths(p, dirPat, recursive)...), nil
+			}
+		}
+		return doListPkgs(pkgPaths, pkgPathBase, patAbs, p, recursive)
 	} else {
 		pkgPaths = append(pkgPaths, pat)
 	}
 	return pkgPaths, nil
 }
 
-func doListPkgs(pkgPaths []string, pkgPathBase, pat string, exts map[string]struct{}, recursive bool) ([]string, error) {
+func doListPkgs(pkgPaths []string, pkgPathBase, pat string, conf *Config, recursive bool) ([]string, error) {
+	exts := conf.SupportedExts
+	if exts == nil {
+		exts = defaultSupportedExts
+	}
 	fis, err := os.ReadDir(pat)
 	if err != nil {
-		return pkgPaths, err
+		if len(overlayFiles(conf, pat)) == 0 && len(overlaySubdirs(conf, pat, nil)) == 0 {
+			return pkgPaths, err
+		}
+		fis = nil // pat only exists through conf.Overlay
 	}
+	existingNames := make(map[string]bool, len(fis))
 	noSouceFile := true
+	var testFiles []string // matched _test.go files, only collected when conf.Tests
 	for _, fi := range fis {
 		name := fi.Name()
 		if strings.HasPrefix(name, "_") {
 			continue
 		}
+		existingNames[name] = true
 		if fi.IsDir() {
 			if recursive {
-				pkgPaths, _ = doListPkgs(pkgPaths, pkgPathBase+"/"+name, pat+"/"+name, exts, true)
-			}
-		} else if noSouceFile {
-			ext := path.Ext(name)
-			if _, ok := exts[ext]; ok {
-				noSouceFile = false
+				pkgPaths, _ = doListPkgs(pkgPaths, pkgPathBase+"/"+name, pat+"/"+name, conf, true)
 			}
+			continue
+		}
+		ext := path.Ext(name)
+		if _, ok := exts[ext]; !ok || (!conf.Tests && !noSouceFile) {
+			continue
+		}
+		full := filepath.Join(pat, name)
+		ok, err := MatchFile(full, conf)
+		if err != nil || !ok {
+			continue
+		}
+		if conf.Tests && strings.HasSuffix(name, "_test.go") {
+			testFiles = append(testFiles, full)
+		} else {
+			noSouceFile = false
+		}
+	}
+	for _, op := range overlayFiles(conf, pat) {
+		if !conf.Tests && !noSouceFile {
+			break
+		}
+		if _, ok := exts[path.Ext(op)]; !ok {
+			continue
+		}
+		ok, err := MatchFile(op, conf)
+		if err != nil || !ok {
+			continue
+		}
+		if conf.Tests && strings.HasSuffix(op, "_test.go") {
+			testFiles = append(testFiles, op)
+		} else {
+			noSouceFile = false
+		}
+	}
+	if recursive {
+		for _, name := range overlaySubdirs(conf, pat, existingNames) {
+			pkgPaths, _ = doListPkgs(pkgPaths, pkgPathBase+"/"+name, pat+"/"+name, conf, true)
 		}
 	}
 	if !noSouceFile {
 		pkgPaths = append(pkgPaths, pkgPathBase)
 	}
+	if conf.Tests && len(testFiles) > 0 {
+		pkgPaths = append(pkgPaths, testPkgPaths(conf, pkgPathBase, testFiles)...)
+	}
 	return pkgPaths, nil
 }
 
@@ -123,36 +241,83 @@ func List(conf *Config, pattern ...string) (pkgPaths []string, err error) {
 }
 
 func Load(conf *Config, pattern ...string) (pkgs []*types.Package, err error) {
+	if conf == nil {
+		conf = new(Config)
+	}
 	p, pkgPaths, err := NewImporter(conf, pattern...)
 	if err != nil {
 		return
 	}
 	pkgs = make([]*types.Package, len(pkgPaths))
+
+	conc := conf.Concurrency
+	if conc <= 0 {
+		conc = runtime.GOMAXPROCS(0)
+	}
+	var g errgroup.Group
+	g.SetLimit(conc)
 	for i, pkgPath := range pkgPaths {
-		if pkgs[i], err = p.Import(pkgPath); err != nil {
+		i, pkgPath := i, pkgPath
+		g.Go(func() (e error) {
+			pkgs[i], e = p.Import(pkgPath)
 			return
-		}
+		})
 	}
+	err = g.Wait()
 	return
 }
 
 // ----------------------------------------------------------------------------
 
+// importResult is the in-flight/completed state shared by concurrent
+// Import calls for the same pkgPath, so only one of them actually loads it.
+type importResult struct {
+	wg  sync.WaitGroup
+	pkg *types.Package
+	err error
+}
+
+// pkgExport is the path to a resolved package's compiled export data file.
+type pkgExport = string
+
 type Importer struct {
 	pkgs   map[string]pkgExport
 	loaded map[string]*types.Package
 	fset   *token.FileSet
+
+	// mu guards loaded, fset, and inFlight: token.FileSet.AddFile and the
+	// loaded map (which gcexportdata.Read populates transitively) are not
+	// safe for concurrent use.
+	mu       sync.Mutex
+	inFlight map[string]*importResult
+
+	// loadCount counts completed loadPkgExport invocations; it exists so
+	// tests can confirm singleflight actually dedups concurrent imports.
+	loadCount int32
 }
 
 func NewImporter(conf *Config, pattern ...string) (p *Importer, pkgPaths []string, err error) {
 	if conf == nil {
 		conf = new(Config)
 	}
+	if driverPath(conf) != "" {
+		return newImporterFromDriver(conf, pattern...)
+	}
+	return newImporterListLoad(conf, pattern...)
+}
+
+func newImporterListLoad(conf *Config, pattern ...string) (p *Importer, pkgPaths []string, err error) {
 	pkgPaths, err = List(conf, pattern...)
 	if err != nil {
 		return
 	}
-	pkgs, err := loadDeps(conf.getTempDir(), pkgPaths...)
+	// loadDeps compiles each pkgPath (including the ".test"/"_test" variants
+	// doListPkgs emits when conf.Tests is set) into its own export file,
+	// which `go list -export` places in the Go build cache. It receives conf
+	// itself, so it can forward conf.Overlay (written to temp files under
+	// conf.getTempDir() by writeOverlayJSON), conf.BuildTags and
+	// conf.BuildFlags to that invocation.
+	pkgs, err := loadDeps(conf, pkgPaths...)
 	if err != nil {
 		return
 	}
@@ -165,18 +330,40 @@ func NewImporter(conf *Config, pattern ...string) (p *Importer, pkgPaths []strin
 		loaded = make(map[string]*types.Package)
 	}
 	loaded["unsafe"] = types.Unsafe
-	p = &Importer{pkgs: pkgs, loaded: loaded, fset: fset}
+	p = &Importer{pkgs: pkgs, loaded: loaded, fset: fset, inFlight: make(map[string]*importResult)}
 	return
 }
 
+// Import implements types.Importer. Concurrent calls for the same pkgPath
+// share a single loadPkgExport invocation instead of racing each other.
 func (p *Importer) Import(pkgPath string) (*types.Package, error) {
+	p.mu.Lock()
 	if ret, ok := p.loaded[pkgPath]; ok && ret.Complete() {
+		p.mu.Unlock()
 		return ret, nil
 	}
-	if expfile, ok := p.pkgs[pkgPath]; ok {
-		return p.loadPkgExport(expfile, pkgPath)
+	if r, ok := p.inFlight[pkgPath]; ok {
+		p.mu.Unlock()
+		r.wg.Wait()
+		return r.pkg, r.err
 	}
-	return nil, syscall.ENOENT
+	expfile, ok := p.pkgs[pkgPath]
+	if !ok {
+		p.mu.Unlock()
+		return nil, syscall.ENOENT
+	}
+	r := &importResult{}
+	r.wg.Add(1)
+	p.inFlight[pkgPath] = r
+	p.mu.Unlock()
+
+	r.pkg, r.err = p.loadPkgExport(expfile, pkgPath)
+
+	p.mu.Lock()
+	delete(p.inFlight, pkgPath)
+	p.mu.Unlock()
+	r.wg.Done()
+	return r.pkg, r.err
 }
 
 func (p *Importer) loadPkgExport(expfile string, pkgPath string) (*types.Package, error) {
@@ -185,11 +372,34 @@ func (p *Importer) loadPkgExport(expfile string, pkgPath string) (*types.Package
 		return nil, err
 	}
 	defer f.Close()
-
-	r, err := gcexportdata.NewReader(f)
+	data, err := io.ReadAll(f)
 	if err != nil {
 		return nil, err
 	}
+
+	// Real compiler-emitted .a files wrap their export data in an object-file
+	// header that NewReader scans past; export data written directly by
+	// gcexportdata.Write (as our own tests do) has no such header and can be
+	// read as-is. Try NewReader first and fall back to the raw bytes so both
+	// kinds of export file work.
+	var r io.Reader = bytes.NewReader(data)
+	if nr, err := gcexportdata.NewReader(bytes.NewReader(data)); err == nil {
+		r = nr
+	}
+
+	// gcexportdata.Read both adds file entries to p.fset and (transitively,
+	// for every import it resolves) inserts into p.loaded, so the whole call
+	// has to run under p.mu: giving each worker its own FileSet would lose
+	// the single shared identity that concurrently-loaded packages need for
+	// their common dependencies to compare equal, and the decode can't be
+	// split into an unlocked parsing phase and a locked merge phase through
+	// gcexportdata's public API. So only the os.Open/io.ReadAll above (and,
+	// for driver/ar-wrapped files, NewReader's header scan) happen outside
+	// the lock; the worker pool's concurrency pays off there and in
+	// overlapping I/O across packages, not inside this call.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	atomic.AddInt32(&p.loadCount, 1)
 	return gcexportdata.Read(r, p.fset, p.loaded, pkgPath)
 }
 
@@ -0,0 +1,133 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"encoding/json"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// overlayFiles returns the overlay paths (absolute) whose parent directory
+// is exactly dir, sorted for deterministic output.
+func overlayFiles(conf *Config, dir string) []string {
+	if len(conf.Overlay) == 0 {
+		return nil
+	}
+	var files []string
+	for p := range conf.Overlay {
+		if filepath.Dir(p) == dir {
+			files = append(files, p)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// overlaySubdirs returns the immediate child directory names under dir that
+// exist only because an overlay file lives somewhere beneath them, skipping
+// names already present in existingNames.
+func overlaySubdirs(conf *Config, dir string, existingNames map[string]bool) []string {
+	if len(conf.Overlay) == 0 {
+		return nil
+	}
+	prefix := dir + string(filepath.Separator)
+	seen := make(map[string]bool)
+	var names []string
+	for p := range conf.Overlay {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := p[len(prefix):]
+		sep := strings.IndexRune(rest, filepath.Separator)
+		if sep < 0 {
+			continue // file directly in dir, handled by overlayFiles
+		}
+		name := rest[:sep]
+		if existingNames[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeOverlayJSON materializes conf.Overlay as the JSON file `go build`/
+// `go list`'s -overlay flag expects ({"Replace": {path: replacement}}),
+// writing each overlay's content to its own temp file under
+// conf.getTempDir() for the replacement to point at. It returns "" if
+// conf.Overlay is empty. The returned cleanup func removes every temp file
+// it created and is always safe to call, even after an error.
+func writeOverlayJSON(conf *Config) (file string, cleanup func(), err error) {
+	cleanup = func() {}
+	if len(conf.Overlay) == 0 {
+		return "", cleanup, nil
+	}
+
+	dir := filepath.Join(conf.getTempDir(), "overlay")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	var written []string
+	cleanup = func() {
+		for _, f := range written {
+			os.Remove(f)
+		}
+	}
+
+	replace := make(map[string]string, len(conf.Overlay))
+	i := 0
+	for p, content := range conf.Overlay {
+		dst := filepath.Join(dir, strconv.Itoa(i)+filepath.Ext(p))
+		i++
+		if err = os.WriteFile(dst, content, 0644); err != nil {
+			return
+		}
+		written = append(written, dst)
+		replace[p] = dst
+	}
+
+	data, err := json.Marshal(struct{ Replace map[string]string }{replace})
+	if err != nil {
+		return
+	}
+	f, err := os.CreateTemp(dir, "overlay*.json")
+	if err != nil {
+		return
+	}
+	written = append(written, f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return f.Name(), cleanup, nil
+}
+
+// ImportFrom implements types.ImporterFrom. srcDir and mode are accepted so
+// overlays can participate in relative-style import resolution the way
+// go/types expects; resolution itself still happens by package path, since
+// overlay content is already baked into the export data loadDeps produced.
+func (p *Importer) ImportFrom(pkgPath, srcDir string, mode types.ImportMode) (*types.Package, error) {
+	return p.Import(pkgPath)
+}
@@ -0,0 +1,67 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// packageClauseName returns the name declared by path's package clause,
+// reading content from conf.Overlay when present instead of disk. It parses
+// only as far as the package clause, so it's cheap enough to run per file.
+func packageClauseName(conf *Config, path string) (string, error) {
+	fset := token.NewFileSet()
+	var src interface{}
+	if content, ok := conf.Overlay[path]; ok {
+		src = content
+	}
+	f, err := parser.ParseFile(fset, path, src, parser.PackageClauseOnly)
+	if err != nil {
+		return "", err
+	}
+	return f.Name.Name, nil
+}
+
+// testPkgPaths returns the synthetic test-variant package paths for a
+// directory that contains the given matched _test.go files: pkgBase+".test"
+// if any of them declare the library's own package, and pkgBase+"_test" if
+// any declare an external "*_test" test package. A file's own declared
+// package name decides which bucket it falls into, so this works even in a
+// directory with no library file of its own (e.g. an examples-only
+// directory) to compare against.
+func testPkgPaths(conf *Config, pkgBase string, testFiles []string) []string {
+	var hasInternal, hasExternal bool
+	for _, tf := range testFiles {
+		name, err := packageClauseName(conf, tf)
+		if err != nil {
+			continue
+		}
+		if strings.HasSuffix(name, "_test") {
+			hasExternal = true
+		} else {
+			hasInternal = true
+		}
+	}
+
+	var pkgPaths []string
+	if hasInternal {
+		pkgPaths = append(pkgPaths, pkgBase+".test")
+	}
+	if hasExternal {
+		pkgPaths = append(pkgPaths, pkgBase+"_test")
+	}
+	return pkgPaths
+}
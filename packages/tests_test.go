@@ -0,0 +1,93 @@
+/*
+ Copyright 2021 The GoPlus Authors (goplus.org)
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package packages
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTestPkgPathsInternal(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.go"), "package a\n")
+	tf := filepath.Join(root, "a_test.go")
+	writeTestFile(t, tf, "package a\n")
+	conf := &Config{ModRoot: root, ModPath: "example.com/m"}
+
+	got := testPkgPaths(conf, "example.com/m", []string{tf})
+	want := []string{"example.com/m.test"}
+	if !equalStrings(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestTestPkgPathsExternal(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.go"), "package a\n")
+	tf := filepath.Join(root, "a_test.go")
+	writeTestFile(t, tf, "package a_test\n")
+	conf := &Config{ModRoot: root, ModPath: "example.com/m"}
+
+	got := testPkgPaths(conf, "example.com/m", []string{tf})
+	want := []string{"example.com/m_test"}
+	if !equalStrings(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestTestPkgPathsExternalNoLibFile covers a directory with only a _test.go
+// file and no library file to compare its package name against (e.g. an
+// examples-only directory) — the external package must still be recognized
+// as external.
+func TestTestPkgPathsExternalNoLibFile(t *testing.T) {
+	root := t.TempDir()
+	tf := filepath.Join(root, "example_test.go")
+	writeTestFile(t, tf, "package a_test\n")
+	conf := &Config{ModRoot: root, ModPath: "example.com/m"}
+
+	got := testPkgPaths(conf, "example.com/m", []string{tf})
+	want := []string{"example.com/m_test"}
+	if !equalStrings(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestDoListPkgsTests(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.go"), "package a\n")
+	writeTestFile(t, filepath.Join(root, "a_test.go"), "package a\n")
+	writeTestFile(t, filepath.Join(root, "a_external_test.go"), "package a_test\n")
+	conf := &Config{ModRoot: root, ModPath: "example.com/m", Tests: true}
+
+	pkgPaths, err := doListPkgs(nil, "example.com/m", root, conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"example.com/m", "example.com/m.test", "example.com/m_test"}
+	if !equalStrings(pkgPaths, want) {
+		t.Fatalf("got %v, want %v", pkgPaths, want)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}